@@ -0,0 +1,43 @@
+package restrictedendpoints
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kapi "k8s.io/kubernetes/pkg/apis/core"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RestrictedEndpointsAdmissionConfig is the configuration for the restrictedEndpointsAdmission plugin.
+type RestrictedEndpointsAdmissionConfig struct {
+	metav1.TypeMeta
+
+	// RestrictedCIDRs is a list of CIDRs that Kubernetes Endpoints and EndpointSlice objects
+	// may not reference, on top of the plugin's built-in defaults.
+	RestrictedCIDRs []string
+
+	// AdditionalRestrictedCIDRs is an alias for RestrictedCIDRs kept for administrators who
+	// want the additive relationship with the built-in defaults to be explicit in their
+	// config. Entries from both fields are merged.
+	AdditionalRestrictedCIDRs []string
+
+	// AllowIngressLoopback, if true, removes the plugin's built-in default restricted networks (link-local/fe80::/10) from the restricted set.
+	AllowIngressLoopback bool
+
+	// ClusterNetworkCIDRs is a list of the cluster's own service and pod network CIDRs.
+	// Installers populate this from the cluster Network config so that endpoints can't be used
+	// to spoof the cluster's internal networks; entries are treated as built-in defaults, the
+	// same as the link-local set, rather than operator policy.
+	ClusterNetworkCIDRs []string
+
+	// RestrictedPorts is a list of ports that Kubernetes Endpoints and EndpointSlice objects
+	// may not reference, in addition to the plugin's built-in default of the MachineConfig
+	// server ports (TCP 22623/22624). Each entry's Protocol must be TCP, UDP, or SCTP, and its
+	// Port must be in the range 1-65535.
+	RestrictedPorts []kapi.EndpointPort
+
+	// EscalationSubresource is the subresource used, together with the "endpoints" resource,
+	// to check whether a user is allowed to write a restricted address or port. It defaults to
+	// "restricted", i.e. the "endpoints/restricted" subresource, but downstream distributions
+	// may re-use this plugin with their own RBAC verb.
+	EscalationSubresource string
+}
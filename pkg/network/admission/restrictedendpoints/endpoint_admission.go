@@ -5,9 +5,14 @@ import (
 	"io"
 	"net"
 	"reflect"
+	"strconv"
+	"strings"
 
 	"k8s.io/klog"
 
+	discovery "k8s.io/api/discovery/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apiserver/pkg/admission"
 	"k8s.io/apiserver/pkg/admission/initializer"
 	"k8s.io/apiserver/pkg/authorization/authorizer"
@@ -19,6 +24,22 @@ import (
 
 const RestrictedEndpointsPluginName = "network.openshift.io/RestrictedEndpointsAdmission"
 
+// endpointSliceGroupResource is the GroupResource shared by discovery.k8s.io/v1 and
+// discovery.k8s.io/v1beta1 EndpointSlices; the version doesn't change which objects SDN
+// and kube-proxy end up programming.
+var endpointSliceGroupResource = schema.GroupResource{Group: "discovery.k8s.io", Resource: "endpointslices"}
+
+// defaultRestrictedNetworks returns the built-in networks that are restricted on top of the
+// plugin config, unless the config sets AllowIngressLoopback.
+func defaultRestrictedNetworks() []*net.IPNet {
+	networks, err := ParseSimpleCIDRRules([]string{"169.254.0.0/16", "fe80::/10"})
+	if err != nil {
+		// the rules above are constants and always parse
+		panic(err)
+	}
+	return networks
+}
+
 func RegisterRestrictedEndpoints(plugins *admission.Plugins) {
 	plugins.Register(RestrictedEndpointsPluginName,
 		func(config io.Reader) (admission.Interface, error) {
@@ -30,13 +51,17 @@ func RegisterRestrictedEndpoints(plugins *admission.Plugins) {
 				klog.Infof("Admission plugin %q is not configured so it will be disabled.", RestrictedEndpointsPluginName)
 				return nil, nil
 			}
-			restrictedNetworks, err := ParseSimpleCIDRRules(pluginConfig.RestrictedCIDRs)
+			restrictedNetworks, err := ParseSimpleCIDRRules(append(append([]string{}, pluginConfig.RestrictedCIDRs...), pluginConfig.AdditionalRestrictedCIDRs...))
 			if err != nil {
 				// should have been caught with validation
 				return nil, err
 			}
 
-			return NewRestrictedEndpointsAdmission(restrictedNetworks), nil
+			admissionPlugin := NewRestrictedEndpointsAdmission(restrictedNetworks, pluginConfig.AllowIngressLoopback, pluginConfig.RestrictedPorts, pluginConfig.EscalationSubresource)
+			if len(pluginConfig.ClusterNetworkCIDRs) > 0 {
+				admissionPlugin.SetClusterNetworkCIDRs(staticClusterNetworkCIDRs(pluginConfig.ClusterNetworkCIDRs))
+			}
+			return admissionPlugin, nil
 		})
 }
 
@@ -55,25 +80,84 @@ func readConfig(reader io.Reader) (*restrictedendpoints.RestrictedEndpointsAdmis
 	if !ok {
 		return nil, fmt.Errorf("unexpected config object: %#v", obj)
 	}
-	// No validation needed since config is just list of strings
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
 	return config, nil
 }
 
+func validateConfig(config *restrictedendpoints.RestrictedEndpointsAdmissionConfig) error {
+	cidrs := append(append([]string{}, config.RestrictedCIDRs...), config.AdditionalRestrictedCIDRs...)
+	cidrs = append(cidrs, config.ClusterNetworkCIDRs...)
+	if _, err := ParseSimpleCIDRRules(cidrs); err != nil {
+		return err
+	}
+	for _, port := range config.RestrictedPorts {
+		switch port.Protocol {
+		case kapi.ProtocolTCP, kapi.ProtocolUDP, kapi.ProtocolSCTP:
+		default:
+			return fmt.Errorf("restrictedPorts: protocol %q must be one of TCP, UDP, SCTP", port.Protocol)
+		}
+		if port.Port < 1 || port.Port > 65535 {
+			return fmt.Errorf("restrictedPorts: port %d must be between 1 and 65535", port.Port)
+		}
+	}
+	return nil
+}
+
+type restrictedNetwork struct {
+	network *net.IPNet
+	// isDefault distinguishes a built-in or cluster-derived restriction from one an
+	// administrator listed explicitly in the plugin config, purely for the forbidden message.
+	isDefault bool
+}
+
 type restrictedEndpointsAdmission struct {
 	*admission.Handler
 
-	authorizer         authorizer.Authorizer
-	restrictedNetworks []*net.IPNet
-	restrictedPorts    []kapi.EndpointPort
+	authorizer            authorizer.Authorizer
+	restrictedNetworks    []restrictedNetwork
+	restrictedPorts       []kapi.EndpointPort
+	escalationSubresource string
+}
+
+// defaultEscalationSubresource is the subresource checked, together with the "endpoints"
+// resource, to see whether a user is allowed to write a restricted address or port.
+const defaultEscalationSubresource = "restricted"
+
+// ClusterNetworkCIDRs is implemented by something that can supply the cluster's configured
+// service and pod network CIDRs, such as an initializer built on top of the cluster Network
+// config.
+type ClusterNetworkCIDRs interface {
+	ServiceAndPodNetworkCIDRs() ([]*net.IPNet, error)
+}
+
+// WantsClusterNetworkCIDRs is implemented by plugins that need to auto-derive the cluster's
+// service and pod network CIDRs and add them to their restricted set at startup.
+type WantsClusterNetworkCIDRs interface {
+	SetClusterNetworkCIDRs(ClusterNetworkCIDRs)
+	admission.InitializationValidator
+}
+
+// staticClusterNetworkCIDRs implements ClusterNetworkCIDRs from a fixed list of CIDR strings,
+// such as the ClusterNetworkCIDRs plugin config field populated by the installer from the
+// cluster Network config.
+type staticClusterNetworkCIDRs []string
+
+func (s staticClusterNetworkCIDRs) ServiceAndPodNetworkCIDRs() ([]*net.IPNet, error) {
+	return ParseSimpleCIDRRules([]string(s))
 }
 
 var _ = initializer.WantsAuthorizer(&restrictedEndpointsAdmission{})
+var _ = WantsClusterNetworkCIDRs(&restrictedEndpointsAdmission{})
 var _ = admission.ValidationInterface(&restrictedEndpointsAdmission{})
 
-// ParseSimpleCIDRRules parses a list of CIDR strings
+// ParseSimpleCIDRRules parses a list of CIDR strings. Parsing is sloppy in that it tolerates
+// IPv4 octets with leading zeros (e.g. "010.0.0.0/8"), which show up in older install configs
+// but which net.ParseCIDR rejects outright.
 func ParseSimpleCIDRRules(rules []string) (networks []*net.IPNet, err error) {
 	for _, s := range rules {
-		_, cidr, err := net.ParseCIDR(s)
+		_, cidr, err := sloppyParseCIDR(s)
 		if err != nil {
 			return nil, err
 		}
@@ -82,15 +166,66 @@ func ParseSimpleCIDRRules(rules []string) (networks []*net.IPNet, err error) {
 	return networks, nil
 }
 
-// NewRestrictedEndpointsAdmission creates a new endpoints admission plugin.
-func NewRestrictedEndpointsAdmission(restrictedNetworks []*net.IPNet) *restrictedEndpointsAdmission {
+// sloppyParseCIDR behaves like net.ParseCIDR, but if the string doesn't parse as-is, it
+// strips any leading zeros from the IPv4 octets and tries again.
+func sloppyParseCIDR(s string) (net.IP, *net.IPNet, error) {
+	if ip, ipnet, err := net.ParseCIDR(s); err == nil {
+		return ip, ipnet, nil
+	}
+
+	slash := strings.IndexByte(s, '/')
+	if slash == -1 {
+		_, _, err := net.ParseCIDR(s)
+		return nil, nil, err
+	}
+	octets := strings.Split(s[:slash], ".")
+	if len(octets) != 4 {
+		_, _, err := net.ParseCIDR(s)
+		return nil, nil, err
+	}
+	for i, octet := range octets {
+		trimmed := strings.TrimLeft(octet, "0")
+		if trimmed == "" {
+			trimmed = "0"
+		}
+		if _, convErr := strconv.Atoi(trimmed); convErr != nil {
+			_, _, err := net.ParseCIDR(s)
+			return nil, nil, err
+		}
+		octets[i] = trimmed
+	}
+	return net.ParseCIDR(strings.Join(octets, ".") + s[slash:])
+}
+
+// NewRestrictedEndpointsAdmission creates a new endpoints admission plugin. The plugin's
+// built-in default networks (see defaultRestrictedNetworks) are always merged in unless
+// allowIngressLoopback is set, and the MachineConfig server ports (TCP 22623/22624) are always
+// merged into restrictedPorts. If escalationSubresource is empty, it defaults to "restricted".
+func NewRestrictedEndpointsAdmission(restrictedNetworks []*net.IPNet, allowIngressLoopback bool, restrictedPorts []kapi.EndpointPort, escalationSubresource string) *restrictedEndpointsAdmission {
+	networks := make([]restrictedNetwork, 0, len(restrictedNetworks))
+	for _, n := range restrictedNetworks {
+		networks = append(networks, restrictedNetwork{network: n})
+	}
+	if !allowIngressLoopback {
+		for _, n := range defaultRestrictedNetworks() {
+			networks = append(networks, restrictedNetwork{network: n, isDefault: true})
+		}
+	}
+
+	ports := append([]kapi.EndpointPort{
+		{Protocol: kapi.ProtocolTCP, Port: 22623},
+		{Protocol: kapi.ProtocolTCP, Port: 22624},
+	}, restrictedPorts...)
+
+	if escalationSubresource == "" {
+		escalationSubresource = defaultEscalationSubresource
+	}
+
 	return &restrictedEndpointsAdmission{
-		Handler:            admission.NewHandler(admission.Create, admission.Update),
-		restrictedNetworks: restrictedNetworks,
-		restrictedPorts: []kapi.EndpointPort{
-			{Protocol: kapi.ProtocolTCP, Port: 22623},
-			{Protocol: kapi.ProtocolTCP, Port: 22624},
-		},
+		Handler:               admission.NewHandler(admission.Create, admission.Update),
+		restrictedNetworks:    networks,
+		restrictedPorts:       ports,
+		escalationSubresource: escalationSubresource,
 	}
 }
 
@@ -98,6 +233,19 @@ func (r *restrictedEndpointsAdmission) SetAuthorizer(a authorizer.Authorizer) {
 	r.authorizer = a
 }
 
+// SetClusterNetworkCIDRs merges the cluster's service and pod network CIDRs into the
+// restricted set, marked as default (as opposed to operator-configured) restrictions.
+func (r *restrictedEndpointsAdmission) SetClusterNetworkCIDRs(cidrs ClusterNetworkCIDRs) {
+	networks, err := cidrs.ServiceAndPodNetworkCIDRs()
+	if err != nil {
+		klog.Errorf("Could not determine cluster service/pod network CIDRs for %s: %v", RestrictedEndpointsPluginName, err)
+		return
+	}
+	for _, n := range networks {
+		r.restrictedNetworks = append(r.restrictedNetworks, restrictedNetwork{network: n, isDefault: true})
+	}
+}
+
 func (r *restrictedEndpointsAdmission) ValidateInitialization() error {
 	if r.authorizer == nil {
 		return fmt.Errorf("missing authorizer")
@@ -105,17 +253,43 @@ func (r *restrictedEndpointsAdmission) ValidateInitialization() error {
 	return nil
 }
 
+func (r *restrictedEndpointsAdmission) restrictedIP(ip string) error {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil
+	}
+	for _, restricted := range r.restrictedNetworks {
+		if restricted.network.Contains(parsed) {
+			if restricted.isDefault {
+				return fmt.Errorf("endpoint address %s is restricted by default policy", ip)
+			}
+			return fmt.Errorf("endpoint address %s is restricted by policy", ip)
+		}
+	}
+	return nil
+}
+
+func (r *restrictedEndpointsAdmission) restrictedPort(protocol kapi.Protocol, port int32) error {
+	for _, restricted := range r.restrictedPorts {
+		if protocol == restricted.Protocol && port == restricted.Port {
+			return fmt.Errorf("endpoint port %s:%d is not allowed", string(protocol), port)
+		}
+	}
+	return nil
+}
+
 func (r *restrictedEndpointsAdmission) findRestrictedIP(ep *kapi.Endpoints) error {
 	for _, subset := range ep.Subsets {
 		for _, addr := range subset.Addresses {
-			ip := net.ParseIP(addr.IP)
-			if ip == nil {
-				continue
+			if err := r.restrictedIP(addr.IP); err != nil {
+				return err
 			}
-			for _, net := range r.restrictedNetworks {
-				if net.Contains(ip) {
-					return fmt.Errorf("endpoint address %s is not allowed", addr.IP)
-				}
+		}
+		// NotReadyAddresses are still programmed into SDN/kube-proxy rules, so they're
+		// just as capable of being used to spoof a restricted destination.
+		for _, addr := range subset.NotReadyAddresses {
+			if err := r.restrictedIP(addr.IP); err != nil {
+				return err
 			}
 		}
 	}
@@ -125,23 +299,80 @@ func (r *restrictedEndpointsAdmission) findRestrictedIP(ep *kapi.Endpoints) erro
 func (r *restrictedEndpointsAdmission) findRestrictedPort(ep *kapi.Endpoints) error {
 	for _, subset := range ep.Subsets {
 		for _, port := range subset.Ports {
-			for _, restricted := range r.restrictedPorts {
-				if port.Protocol == restricted.Protocol && port.Port == restricted.Port {
-					return fmt.Errorf("endpoint port %s:%d is not allowed", string(port.Protocol), port.Port)
-				}
+			if err := r.restrictedPort(port.Protocol, port.Port); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *restrictedEndpointsAdmission) findRestrictedIPInEndpointSliceAddresses(addresses [][]string) error {
+	for _, endpointAddrs := range addresses {
+		for _, addr := range endpointAddrs {
+			if err := r.restrictedIP(addr); err != nil {
+				return err
 			}
 		}
 	}
 	return nil
 }
 
+func (r *restrictedEndpointsAdmission) findRestrictedPortInEndpointSlice(slice *discovery.EndpointSlice) error {
+	for _, port := range slice.Ports {
+		if port.Port == nil {
+			continue
+		}
+		protocol := kapi.ProtocolTCP
+		if port.Protocol != nil {
+			protocol = kapi.Protocol(*port.Protocol)
+		}
+		if err := r.restrictedPort(protocol, *port.Port); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *restrictedEndpointsAdmission) findRestrictedPortInV1beta1EndpointSlice(slice *discoveryv1beta1.EndpointSlice) error {
+	for _, port := range slice.Ports {
+		if port.Port == nil {
+			continue
+		}
+		protocol := kapi.ProtocolTCP
+		if port.Protocol != nil {
+			protocol = kapi.Protocol(*port.Protocol)
+		}
+		if err := r.restrictedPort(protocol, *port.Port); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func endpointSliceAddresses(endpoints []discovery.Endpoint) [][]string {
+	addresses := make([][]string, 0, len(endpoints))
+	for _, ep := range endpoints {
+		addresses = append(addresses, ep.Addresses)
+	}
+	return addresses
+}
+
+func v1beta1EndpointSliceAddresses(endpoints []discoveryv1beta1.Endpoint) [][]string {
+	addresses := make([][]string, 0, len(endpoints))
+	for _, ep := range endpoints {
+		addresses = append(addresses, ep.Addresses)
+	}
+	return addresses
+}
+
 func (r *restrictedEndpointsAdmission) checkAccess(attr admission.Attributes) (bool, error) {
 	authzAttr := authorizer.AttributesRecord{
 		User:            attr.GetUserInfo(),
 		Verb:            "create",
 		Namespace:       attr.GetNamespace(),
 		Resource:        "endpoints",
-		Subresource:     "restricted",
+		Subresource:     r.escalationSubresource,
 		APIGroup:        kapi.GroupName,
 		Name:            attr.GetName(),
 		ResourceRequest: true,
@@ -150,24 +381,57 @@ func (r *restrictedEndpointsAdmission) checkAccess(attr admission.Attributes) (b
 	return authorized == authorizer.DecisionAllow, err
 }
 
-// Admit determines if the endpoints object should be admitted
+// Validate determines if the endpoints or endpointslice object should be admitted
 func (r *restrictedEndpointsAdmission) Validate(a admission.Attributes) error {
-	if a.GetResource().GroupResource() != kapi.Resource("endpoints") {
-		return nil
-	}
-	ep, ok := a.GetObject().(*kapi.Endpoints)
-	if !ok {
-		return nil
-	}
-	old, ok := a.GetOldObject().(*kapi.Endpoints)
-	if ok && reflect.DeepEqual(ep.Subsets, old.Subsets) {
+	var kind string
+	var restrictedErr error
+
+	switch {
+	case a.GetResource().GroupResource() == kapi.Resource("endpoints"):
+		ep, ok := a.GetObject().(*kapi.Endpoints)
+		if !ok {
+			return nil
+		}
+		old, ok := a.GetOldObject().(*kapi.Endpoints)
+		if ok && reflect.DeepEqual(ep.Subsets, old.Subsets) {
+			return nil
+		}
+
+		kind = "Endpoints"
+		restrictedErr = r.findRestrictedIP(ep)
+		if restrictedErr == nil {
+			restrictedErr = r.findRestrictedPort(ep)
+		}
+
+	case a.GetResource().GroupResource() == endpointSliceGroupResource:
+		kind = "EndpointSlice"
+		switch slice := a.GetObject().(type) {
+		case *discovery.EndpointSlice:
+			old, ok := a.GetOldObject().(*discovery.EndpointSlice)
+			if ok && reflect.DeepEqual(slice.Endpoints, old.Endpoints) && reflect.DeepEqual(slice.Ports, old.Ports) {
+				return nil
+			}
+			restrictedErr = r.findRestrictedIPInEndpointSliceAddresses(endpointSliceAddresses(slice.Endpoints))
+			if restrictedErr == nil {
+				restrictedErr = r.findRestrictedPortInEndpointSlice(slice)
+			}
+		case *discoveryv1beta1.EndpointSlice:
+			old, ok := a.GetOldObject().(*discoveryv1beta1.EndpointSlice)
+			if ok && reflect.DeepEqual(slice.Endpoints, old.Endpoints) && reflect.DeepEqual(slice.Ports, old.Ports) {
+				return nil
+			}
+			restrictedErr = r.findRestrictedIPInEndpointSliceAddresses(v1beta1EndpointSliceAddresses(slice.Endpoints))
+			if restrictedErr == nil {
+				restrictedErr = r.findRestrictedPortInV1beta1EndpointSlice(slice)
+			}
+		default:
+			return nil
+		}
+
+	default:
 		return nil
 	}
 
-	restrictedErr := r.findRestrictedIP(ep)
-	if restrictedErr == nil {
-		restrictedErr = r.findRestrictedPort(ep)
-	}
 	if restrictedErr == nil {
 		return nil
 	}
@@ -177,7 +441,7 @@ func (r *restrictedEndpointsAdmission) Validate(a admission.Attributes) error {
 		return err
 	}
 	if !allow {
-		return admission.NewForbidden(a, restrictedErr)
+		return admission.NewForbidden(a, fmt.Errorf("%s: %v", kind, restrictedErr))
 	}
 	return nil
 }
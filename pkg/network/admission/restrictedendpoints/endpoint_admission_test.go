@@ -0,0 +1,267 @@
+package restrictedendpoints
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	discovery "k8s.io/api/discovery/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	kapi "k8s.io/kubernetes/pkg/apis/core"
+
+	"github.com/openshift/origin/pkg/network/admission/apis/restrictedendpoints"
+)
+
+type fakeAuthorizer struct {
+	allow bool
+	// gotSubresource, if non-nil, is set to the Subresource of the last Authorize call.
+	gotSubresource *string
+}
+
+func (f fakeAuthorizer) Authorize(a authorizer.Attributes) (authorizer.Decision, string, error) {
+	if f.gotSubresource != nil {
+		*f.gotSubresource = a.GetSubresource()
+	}
+	if f.allow {
+		return authorizer.DecisionAllow, "", nil
+	}
+	return authorizer.DecisionDeny, "", nil
+}
+
+func TestParseSimpleCIDRRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		wantErr bool
+	}{
+		{name: "plain IPv4", rule: "10.0.0.0/8"},
+		{name: "plain IPv6", rule: "fe80::/10"},
+		{name: "sloppy leading zeros", rule: "010.0.0.0/8"},
+		{name: "sloppy leading zeros in every octet", rule: "010.000.000.000/8"},
+		{name: "out of range octet is still invalid", rule: "300.0.0.0/8", wantErr: true},
+		{name: "garbage is still invalid", rule: "not-a-cidr", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			networks, err := ParseSimpleCIDRRules([]string{tt.rule})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseSimpleCIDRRules(%q) = nil error, want error", tt.rule)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSimpleCIDRRules(%q) = %v, want no error", tt.rule, err)
+			}
+			if len(networks) != 1 {
+				t.Fatalf("ParseSimpleCIDRRules(%q) returned %d networks, want 1", tt.rule, len(networks))
+			}
+		})
+	}
+}
+
+func TestFindRestrictedIPNotReadyAddresses(t *testing.T) {
+	_, restricted, err := net.ParseCIDR("10.0.1.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &restrictedEndpointsAdmission{restrictedNetworks: []restrictedNetwork{{network: restricted}}}
+
+	ep := &kapi.Endpoints{
+		Subsets: []kapi.EndpointSubset{
+			{
+				Addresses:         []kapi.EndpointAddress{{IP: "192.168.0.1"}},
+				NotReadyAddresses: []kapi.EndpointAddress{{IP: "10.0.1.5"}},
+			},
+		},
+	}
+
+	if err := r.findRestrictedIP(ep); err == nil {
+		t.Fatal("findRestrictedIP with a restricted NotReadyAddress = nil error, want error")
+	}
+
+	ep.Subsets[0].NotReadyAddresses = nil
+	if err := r.findRestrictedIP(ep); err != nil {
+		t.Fatalf("findRestrictedIP with no restricted addresses = %v, want no error", err)
+	}
+}
+
+func TestRestrictedIPMessageDistinguishesDefaultFromPolicy(t *testing.T) {
+	r := NewRestrictedEndpointsAdmission(mustParseCIDRs(t, "10.0.1.0/24"), false, nil, "")
+
+	if err := r.restrictedIP("10.0.1.5"); err == nil || !strings.Contains(err.Error(), "restricted by policy") {
+		t.Fatalf("restrictedIP for an operator-configured CIDR = %v, want an error mentioning \"restricted by policy\"", err)
+	}
+	if err := r.restrictedIP("169.254.169.254"); err == nil || !strings.Contains(err.Error(), "restricted by default policy") {
+		t.Fatalf("restrictedIP for the built-in default metadata CIDR = %v, want an error mentioning \"restricted by default policy\"", err)
+	}
+}
+
+func TestAllowIngressLoopback(t *testing.T) {
+	r := NewRestrictedEndpointsAdmission(nil, true, nil, "")
+	if err := r.restrictedIP("169.254.169.254"); err != nil {
+		t.Fatalf("restrictedIP for the metadata address with AllowIngressLoopback = %v, want no error", err)
+	}
+}
+
+func TestSetClusterNetworkCIDRs(t *testing.T) {
+	r := NewRestrictedEndpointsAdmission(nil, true, nil, "")
+	r.SetClusterNetworkCIDRs(staticClusterNetworkCIDRs{"172.30.0.0/16"})
+
+	if err := r.restrictedIP("172.30.0.1"); err == nil || !strings.Contains(err.Error(), "restricted by default policy") {
+		t.Fatalf("restrictedIP for a cluster-derived CIDR = %v, want an error mentioning \"restricted by default policy\"", err)
+	}
+}
+
+func mustParseCIDRs(t *testing.T, rules ...string) []*net.IPNet {
+	t.Helper()
+	networks, err := ParseSimpleCIDRRules(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return networks
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  restrictedendpoints.RestrictedEndpointsAdmissionConfig
+		wantErr bool
+	}{
+		{
+			name: "valid config",
+			config: restrictedendpoints.RestrictedEndpointsAdmissionConfig{
+				RestrictedCIDRs: []string{"10.0.0.0/8"},
+				RestrictedPorts: []kapi.EndpointPort{{Protocol: kapi.ProtocolTCP, Port: 2379}},
+			},
+		},
+		{
+			name: "invalid CIDR",
+			config: restrictedendpoints.RestrictedEndpointsAdmissionConfig{
+				RestrictedCIDRs: []string{"not-a-cidr"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "bad protocol",
+			config: restrictedendpoints.RestrictedEndpointsAdmissionConfig{
+				RestrictedPorts: []kapi.EndpointPort{{Protocol: "ICMP", Port: 2379}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "port too low",
+			config: restrictedendpoints.RestrictedEndpointsAdmissionConfig{
+				RestrictedPorts: []kapi.EndpointPort{{Protocol: kapi.ProtocolTCP, Port: 0}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "port too high",
+			config: restrictedendpoints.RestrictedEndpointsAdmissionConfig{
+				RestrictedPorts: []kapi.EndpointPort{{Protocol: kapi.ProtocolTCP, Port: 65536}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateConfig(&tt.config)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("validateConfig() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func int32Ptr(i int32) *int32 { return &i }
+
+func protocolPtr(p discovery.Protocol) *discovery.Protocol { return &p }
+
+func protocolv1beta1Ptr(p discoveryv1beta1.Protocol) *discoveryv1beta1.Protocol { return &p }
+
+func TestValidateEndpointSlice(t *testing.T) {
+	restrictedNetworks := mustParseCIDRs(t, "10.0.1.0/24")
+	endpointsGVR := schema.GroupVersionResource{Version: "v1", Resource: "endpoints"}
+	sliceGVR := schema.GroupVersionResource{Group: "discovery.k8s.io", Version: "v1", Resource: "endpointslices"}
+	sliceGVK := schema.GroupVersionKind{Group: "discovery.k8s.io", Version: "v1", Kind: "EndpointSlice"}
+	v1beta1SliceGVR := schema.GroupVersionResource{Group: "discovery.k8s.io", Version: "v1beta1", Resource: "endpointslices"}
+	v1beta1SliceGVK := schema.GroupVersionKind{Group: "discovery.k8s.io", Version: "v1beta1", Kind: "EndpointSlice"}
+	userInfo := &user.DefaultInfo{Name: "alice"}
+
+	newAdmission := func(allow bool) *restrictedEndpointsAdmission {
+		r := NewRestrictedEndpointsAdmission(restrictedNetworks, true, nil, "")
+		r.SetAuthorizer(fakeAuthorizer{allow: allow})
+		return r
+	}
+
+	t.Run("v1 EndpointSlice with a restricted address is denied without escalation", func(t *testing.T) {
+		r := newAdmission(false)
+		slice := &discovery.EndpointSlice{
+			Endpoints: []discovery.Endpoint{{Addresses: []string{"10.0.1.5"}}},
+			Ports:     []discovery.EndpointPort{{Port: int32Ptr(80), Protocol: protocolPtr(discovery.ProtocolTCP)}},
+		}
+		attrs := admission.NewAttributesRecord(slice, nil, sliceGVK, "ns", "svc", sliceGVR, "", admission.Create, nil, false, userInfo)
+		if err := r.Validate(attrs); err == nil {
+			t.Fatal("Validate() = nil error, want a forbidden error")
+		}
+	})
+
+	t.Run("v1 EndpointSlice with a restricted address is admitted with escalation", func(t *testing.T) {
+		r := newAdmission(true)
+		slice := &discovery.EndpointSlice{
+			Endpoints: []discovery.Endpoint{{Addresses: []string{"10.0.1.5"}}},
+		}
+		attrs := admission.NewAttributesRecord(slice, nil, sliceGVK, "ns", "svc", sliceGVR, "", admission.Create, nil, false, userInfo)
+		if err := r.Validate(attrs); err != nil {
+			t.Fatalf("Validate() = %v, want no error", err)
+		}
+	})
+
+	t.Run("v1beta1 EndpointSlice restricted port is denied without escalation", func(t *testing.T) {
+		r := newAdmission(false)
+		slice := &discoveryv1beta1.EndpointSlice{
+			Endpoints: []discoveryv1beta1.Endpoint{{Addresses: []string{"192.168.0.1"}}},
+			Ports:     []discoveryv1beta1.EndpointPort{{Port: int32Ptr(22623), Protocol: protocolv1beta1Ptr(discoveryv1beta1.ProtocolTCP)}},
+		}
+		attrs := admission.NewAttributesRecord(slice, nil, v1beta1SliceGVK, "ns", "svc", v1beta1SliceGVR, "", admission.Create, nil, false, userInfo)
+		if err := r.Validate(attrs); err == nil {
+			t.Fatal("Validate() = nil error, want a forbidden error")
+		}
+	})
+
+	t.Run("unrestricted Endpoints is admitted", func(t *testing.T) {
+		r := newAdmission(false)
+		ep := &kapi.Endpoints{
+			Subsets: []kapi.EndpointSubset{{Addresses: []kapi.EndpointAddress{{IP: "192.168.0.1"}}}},
+		}
+		attrs := admission.NewAttributesRecord(ep, nil, schema.GroupVersionKind{Version: "v1", Kind: "Endpoints"}, "ns", "svc", endpointsGVR, "", admission.Create, nil, false, userInfo)
+		if err := r.Validate(attrs); err != nil {
+			t.Fatalf("Validate() = %v, want no error", err)
+		}
+	})
+
+	t.Run("custom restricted port is denied and checked against the configured escalation subresource", func(t *testing.T) {
+		var gotSubresource string
+		r := NewRestrictedEndpointsAdmission(nil, true, []kapi.EndpointPort{{Protocol: kapi.ProtocolTCP, Port: 2379}}, "custom-escalation")
+		r.SetAuthorizer(fakeAuthorizer{allow: false, gotSubresource: &gotSubresource})
+
+		ep := &kapi.Endpoints{
+			Subsets: []kapi.EndpointSubset{{
+				Addresses: []kapi.EndpointAddress{{IP: "192.168.0.1"}},
+				Ports:     []kapi.EndpointPort{{Protocol: kapi.ProtocolTCP, Port: 2379}},
+			}},
+		}
+		attrs := admission.NewAttributesRecord(ep, nil, schema.GroupVersionKind{Version: "v1", Kind: "Endpoints"}, "ns", "svc", endpointsGVR, "", admission.Create, nil, false, userInfo)
+		if err := r.Validate(attrs); err == nil {
+			t.Fatal("Validate() = nil error, want a forbidden error for the custom restricted port")
+		}
+		if gotSubresource != "custom-escalation" {
+			t.Fatalf("authorizer check used subresource %q, want %q", gotSubresource, "custom-escalation")
+		}
+	})
+}